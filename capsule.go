@@ -0,0 +1,109 @@
+package webtransport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/lucas-clemente/quic-go/quicvarint"
+)
+
+// CapsuleType is the type of an HTTP Capsule, as defined in RFC 9297.
+type CapsuleType uint64
+
+const (
+	closeWebtransportSessionCapsuleType CapsuleType = 0x2843
+	drainWebtransportSessionCapsuleType CapsuleType = 0x78ae
+)
+
+// maxCloseReasonLen is the maximum length (in bytes) of the reason phrase carried
+// in a CLOSE_WEBTRANSPORT_SESSION capsule, as defined in the WebTransport draft.
+const maxCloseReasonLen = 1024
+
+// maxCapsuleLen bounds the length field of any capsule read off the CONNECT request
+// stream. Without this bound, a peer could claim an almost-2^62-byte payload and
+// crash the process via an oversized allocation before we ever get to inspect it.
+// It's set well above the largest capsule this package understands (CLOSE's
+// maxCloseReasonLen-bounded payload) to leave room for capsule types forwarded to a
+// registered CapsuleHandlerFunc.
+const maxCapsuleLen = 16384
+
+// CapsuleHandlerFunc handles a capsule of a type not natively understood by this
+// package, received on a session's CONNECT request stream. It is called with the
+// capsule's payload. Returning an error tears down the WebTransport session.
+type CapsuleHandlerFunc func(payload []byte) error
+
+// singleByteReader adapts an io.Reader into an io.ByteReader by reading exactly one
+// byte per ReadByte call. Unlike a buffering reader, it never consumes more bytes
+// from the underlying stream than asked for, so it's safe to use on a stream whose
+// remaining bytes must stay readable afterwards.
+type singleByteReader struct {
+	io.Reader
+}
+
+func (r singleByteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r.Reader, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readCapsule reads a single capsule (a varint type, a varint length, and that many
+// bytes of payload, as defined in RFC 9297) from r.
+func readCapsule(r io.Reader) (CapsuleType, []byte, error) {
+	br := singleByteReader{r}
+	t, err := quicvarint.Read(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	l, err := quicvarint.Read(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	if l > maxCapsuleLen {
+		return 0, nil, fmt.Errorf("capsule too large: %d bytes", l)
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return CapsuleType(t), payload, nil
+}
+
+// writeCapsule writes a single capsule to w.
+func writeCapsule(w io.Writer, t CapsuleType, payload []byte) error {
+	capacity := int(quicvarint.Len(uint64(t))) + int(quicvarint.Len(uint64(len(payload)))) + len(payload)
+	buf := bytes.NewBuffer(make([]byte, 0, capacity))
+	quicvarint.Write(buf, uint64(t))
+	quicvarint.Write(buf, uint64(len(payload)))
+	buf.Write(payload)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// parseCloseWebtransportSessionCapsule parses the payload of a
+// CLOSE_WEBTRANSPORT_SESSION capsule: a 32-bit application error code, followed by a
+// UTF-8 reason phrase of at most maxCloseReasonLen bytes.
+func parseCloseWebtransportSessionCapsule(payload []byte) (*SessionError, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("capsule too short: %d bytes", len(payload))
+	}
+	if len(payload)-4 > maxCloseReasonLen {
+		return nil, fmt.Errorf("reason too long: %d bytes", len(payload)-4)
+	}
+	code := uint32(payload[0])<<24 | uint32(payload[1])<<16 | uint32(payload[2])<<8 | uint32(payload[3])
+	return &SessionError{Code: SessionErrorCode(code), Reason: string(payload[4:])}, nil
+}
+
+// appendCloseWebtransportSessionCapsule encodes the payload of a
+// CLOSE_WEBTRANSPORT_SESSION capsule for the given error code and reason.
+func appendCloseWebtransportSessionCapsule(code SessionErrorCode, reason string) []byte {
+	payload := make([]byte, 4+len(reason))
+	payload[0] = byte(code >> 24)
+	payload[1] = byte(code >> 16)
+	payload[2] = byte(code >> 8)
+	payload[3] = byte(code)
+	copy(payload[4:], reason)
+	return payload
+}
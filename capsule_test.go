@@ -0,0 +1,74 @@
+package webtransport
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go/quicvarint"
+)
+
+func TestCapsuleRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		typ     CapsuleType
+		payload []byte
+	}{
+		{"empty payload", closeWebtransportSessionCapsuleType, nil},
+		{"with payload", drainWebtransportSessionCapsuleType, []byte("some payload")},
+		{"large type", CapsuleType(1 << 40), []byte{1, 2, 3}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			if err := writeCapsule(buf, tt.typ, tt.payload); err != nil {
+				t.Fatalf("writeCapsule failed: %v", err)
+			}
+			typ, payload, err := readCapsule(buf)
+			if err != nil {
+				t.Fatalf("readCapsule failed: %v", err)
+			}
+			if typ != tt.typ {
+				t.Errorf("type mismatch: got %#x, want %#x", typ, tt.typ)
+			}
+			if !bytes.Equal(payload, tt.payload) {
+				t.Errorf("payload mismatch: got %v, want %v", payload, tt.payload)
+			}
+		})
+	}
+}
+
+func TestReadCapsuleRejectsOversizedLength(t *testing.T) {
+	buf := &bytes.Buffer{}
+	quicvarint.Write(buf, uint64(closeWebtransportSessionCapsuleType))
+	quicvarint.Write(buf, maxCapsuleLen+1)
+
+	if _, _, err := readCapsule(buf); err == nil {
+		t.Fatal("expected readCapsule to reject a capsule length above maxCapsuleLen")
+	}
+}
+
+func TestCloseWebtransportSessionCapsuleRoundTrip(t *testing.T) {
+	payload := appendCloseWebtransportSessionCapsule(42, "because reasons")
+	sessionErr, err := parseCloseWebtransportSessionCapsule(payload)
+	if err != nil {
+		t.Fatalf("parseCloseWebtransportSessionCapsule failed: %v", err)
+	}
+	if sessionErr.Code != 42 {
+		t.Errorf("code mismatch: got %d, want 42", sessionErr.Code)
+	}
+	if sessionErr.Reason != "because reasons" {
+		t.Errorf("reason mismatch: got %q, want %q", sessionErr.Reason, "because reasons")
+	}
+}
+
+func TestParseCloseWebtransportSessionCapsuleRejectsTooShort(t *testing.T) {
+	if _, err := parseCloseWebtransportSessionCapsule([]byte{1, 2, 3}); err == nil {
+		t.Fatal("expected an error for a payload shorter than the 4-byte error code")
+	}
+}
+
+func TestParseCloseWebtransportSessionCapsuleRejectsTooLongReason(t *testing.T) {
+	payload := appendCloseWebtransportSessionCapsule(0, string(make([]byte, maxCloseReasonLen+1)))
+	if _, err := parseCloseWebtransportSessionCapsule(payload); err == nil {
+		t.Fatal("expected an error for a reason phrase longer than maxCloseReasonLen")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lucas-clemente/quic-go"
 	"github.com/lucas-clemente/quic-go/http3"
@@ -19,14 +20,33 @@ type sessionID uint64
 type Conn struct {
 	sessionID  sessionID
 	qconn      http3.StreamCreator
-	requestStr io.Reader // TODO: this needs to be an io.ReadWriteCloser so we can close the stream
+	conn       quic.Connection // the underlying QUIC connection, used for sending and receiving datagrams
+	requestStr io.ReadWriteCloser
 
 	streamHdr    []byte
 	uniStreamHdr []byte
 
-	ctx      context.Context
-	closeErr error
-	closed   chan struct{}
+	ctx        context.Context
+	ctxCancel  context.CancelFunc
+	closeErr   error
+	closed     chan struct{}
+	closedOnce sync.Once // guards close(c.closed)
+	closeOnce  sync.Once // guards the side effects of CloseWithError
+
+	capsuleHandlerMx sync.Mutex
+	capsuleHandlers  map[CapsuleType]CapsuleHandlerFunc
+
+	peerDraining    uint32 // accessed atomically
+	locallyDraining uint32 // accessed atomically
+
+	supportsDatagrams bool
+	datagramHdr       []byte
+	datagramQueue     *datagramQueue
+
+	streamsMx      sync.Mutex
+	bidiStreams    map[quic.StreamID]quic.Stream
+	sendStreams    map[quic.StreamID]quic.SendStream
+	receiveStreams map[quic.StreamID]quic.ReceiveStream
 
 	// for bidirectional streams
 	acceptMx   sync.Mutex
@@ -45,16 +65,27 @@ type Conn struct {
 	acceptUniQueue []quic.ReceiveStream
 }
 
-func newConn(sessionID sessionID, qconn http3.StreamCreator, requestStr io.Reader) *Conn {
+// newConn creates a new WebTransport session. supportsDatagrams indicates whether
+// both peers negotiated h3-datagram support (SETTINGS_H3_DATAGRAM); datagramQueueLen
+// configures how many received datagrams are buffered before the oldest is dropped
+// (0 uses DefaultMaxDatagramQueueLen).
+func newConn(sessionID sessionID, qconn http3.StreamCreator, conn quic.Connection, requestStr io.ReadWriteCloser, supportsDatagrams bool, datagramQueueLen int) *Conn {
 	ctx, ctxCancel := context.WithCancel(context.Background())
 	c := &Conn{
-		sessionID:     sessionID,
-		qconn:         qconn,
-		requestStr:    requestStr,
-		ctx:           ctx,
-		closed:        make(chan struct{}),
-		acceptChan:    make(chan struct{}, 1),
-		acceptUniChan: make(chan struct{}, 1),
+		sessionID:         sessionID,
+		qconn:             qconn,
+		conn:              conn,
+		requestStr:        requestStr,
+		ctx:               ctx,
+		ctxCancel:         ctxCancel,
+		closed:            make(chan struct{}),
+		acceptChan:        make(chan struct{}, 1),
+		acceptUniChan:     make(chan struct{}, 1),
+		supportsDatagrams: supportsDatagrams,
+		datagramQueue:     newDatagramQueue(datagramQueueLen),
+		bidiStreams:       make(map[quic.StreamID]quic.Stream),
+		sendStreams:       make(map[quic.StreamID]quic.SendStream),
+		receiveStreams:    make(map[quic.StreamID]quic.ReceiveStream),
 	}
 	// precompute the headers for unidirectional streams
 	buf := bytes.NewBuffer(make([]byte, 0, 2+quicvarint.Len(uint64(c.sessionID))))
@@ -66,26 +97,100 @@ func newConn(sessionID sessionID, qconn http3.StreamCreator, requestStr io.Reade
 	quicvarint.Write(buf, webTransportFrameType)
 	quicvarint.Write(buf, uint64(c.sessionID))
 	c.streamHdr = buf.Bytes()
+	// precompute the header (the Quarter Stream ID) for datagrams
+	buf = bytes.NewBuffer(make([]byte, 0, quicvarint.Len(uint64(c.sessionID/4))))
+	quicvarint.Write(buf, uint64(c.sessionID/4))
+	c.datagramHdr = buf.Bytes()
+
+	getOrCreateSessionManager(qconn, conn).register(sessionID, c)
 
 	go func() {
 		defer ctxCancel()
 		c.handleConn()
+		getOrCreateSessionManager(qconn, conn).unregister(sessionID)
 	}()
 	return c
 }
 
 func (c *Conn) handleConn() {
 	for {
-		// TODO: parse capsules sent on the request stream
-		b := make([]byte, 100)
-		if _, err := c.requestStr.Read(b); err != nil {
-			c.closeErr = fmt.Errorf("WebTransport session closed: %w", err)
-			close(c.closed)
+		typ, payload, err := readCapsule(c.requestStr)
+		if err != nil {
+			c.setClosed(fmt.Errorf("WebTransport session closed: %w", err))
+			return
+		}
+		if done := c.handleCapsule(typ, payload); done {
 			return
 		}
 	}
 }
 
+// handleCapsule processes a single capsule received on the CONNECT request stream.
+// It returns true once the session has been closed (because a well-formed
+// CLOSE_WEBTRANSPORT_SESSION capsule was received, because the capsule was
+// malformed, or because a registered CapsuleHandlerFunc returned an error), in which
+// case c.closeErr and c.closed are already set.
+func (c *Conn) handleCapsule(typ CapsuleType, payload []byte) (done bool) {
+	switch typ {
+	case closeWebtransportSessionCapsuleType:
+		sessionErr, err := parseCloseWebtransportSessionCapsule(payload)
+		if err != nil {
+			c.setClosed(fmt.Errorf("received malformed CLOSE_WEBTRANSPORT_SESSION capsule: %w", err))
+			return true
+		}
+		sessionErr.Remote = true
+		c.setClosed(sessionErr)
+		return true
+	case drainWebtransportSessionCapsuleType:
+		if len(payload) != 0 {
+			c.setClosed(fmt.Errorf("received malformed DRAIN_WEBTRANSPORT_SESSION capsule: unexpected payload of length %d", len(payload)))
+			return true
+		}
+		atomic.StoreUint32(&c.peerDraining, 1)
+		return false
+	default:
+		handler := c.capsuleHandler(typ)
+		if handler == nil {
+			// Unknown capsule types are skipped, as required by RFC 9297.
+			return false
+		}
+		if err := handler(payload); err != nil {
+			c.setClosed(fmt.Errorf("capsule handler for capsule type %d returned an error: %w", typ, err))
+			return true
+		}
+		return false
+	}
+}
+
+// setClosed marks the session as closed with the given error, the first time it's
+// called. Subsequent calls are no-ops: c.closeErr always reflects the first close.
+func (c *Conn) setClosed(err error) {
+	c.closedOnce.Do(func() {
+		c.closeErr = err
+		close(c.closed)
+	})
+}
+
+func (c *Conn) capsuleHandler(typ CapsuleType) CapsuleHandlerFunc {
+	c.capsuleHandlerMx.Lock()
+	defer c.capsuleHandlerMx.Unlock()
+	return c.capsuleHandlers[typ]
+}
+
+// RegisterCapsuleHandler registers a handler for capsules of typ received on this
+// session's CONNECT request stream. Only one handler may be registered per type;
+// registering a new handler replaces any previously registered one. Capsule types
+// natively handled by this package (CLOSE_WEBTRANSPORT_SESSION and
+// DRAIN_WEBTRANSPORT_SESSION) cannot be overridden.
+func (c *Conn) RegisterCapsuleHandler(typ CapsuleType, handler CapsuleHandlerFunc) {
+	c.capsuleHandlerMx.Lock()
+	defer c.capsuleHandlerMx.Unlock()
+	if c.capsuleHandlers == nil {
+		c.capsuleHandlers = make(map[CapsuleType]CapsuleHandlerFunc)
+	}
+	c.capsuleHandlers[typ] = handler
+}
+
 func (c *Conn) isClosed() bool {
 	select {
 	case <-c.closed:
@@ -96,6 +201,8 @@ func (c *Conn) isClosed() bool {
 }
 
 func (c *Conn) addStream(str quic.Stream) {
+	c.trackBidiStream(str)
+
 	c.acceptMx.Lock()
 	defer c.acceptMx.Unlock()
 
@@ -107,6 +214,8 @@ func (c *Conn) addStream(str quic.Stream) {
 }
 
 func (c *Conn) addUniStream(str quic.ReceiveStream) {
+	c.trackReceiveStream(str)
+
 	c.acceptUniMx.Lock()
 	defer c.acceptUniMx.Unlock()
 
@@ -117,6 +226,70 @@ func (c *Conn) addUniStream(str quic.ReceiveStream) {
 	}
 }
 
+// trackBidiStream registers str so that it is reset when the session is closed.
+func (c *Conn) trackBidiStream(str quic.Stream) {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	c.bidiStreams[str.StreamID()] = str
+}
+
+// trackSendStream registers str so that it is reset when the session is closed.
+func (c *Conn) trackSendStream(str quic.SendStream) {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	c.sendStreams[str.StreamID()] = str
+}
+
+// trackReceiveStream registers str so that it is reset when the session is closed.
+func (c *Conn) trackReceiveStream(str quic.ReceiveStream) {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	c.receiveStreams[str.StreamID()] = str
+}
+
+// untrackBidiStream stops tracking the bidi stream with the given ID, once it has
+// finished (so it doesn't leak for as long as the session stays open).
+func (c *Conn) untrackBidiStream(id quic.StreamID) {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	delete(c.bidiStreams, id)
+}
+
+// untrackSendStream stops tracking the send stream with the given ID, once it has
+// finished.
+func (c *Conn) untrackSendStream(id quic.StreamID) {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	delete(c.sendStreams, id)
+}
+
+// untrackReceiveStream stops tracking the receive stream with the given ID, once it
+// has finished.
+func (c *Conn) untrackReceiveStream(id quic.StreamID) {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	delete(c.receiveStreams, id)
+}
+
+// resetAllStreams resets every stream belonging to this session that's still open,
+// using the WebTransport-to-HTTP/3 application error code mapping.
+func (c *Conn) resetAllStreams(code SessionErrorCode) {
+	resetCode := quic.StreamErrorCode(webtransportCodeToHTTPCode(uint64(code)))
+
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	for _, str := range c.bidiStreams {
+		str.CancelRead(resetCode)
+		str.CancelWrite(resetCode)
+	}
+	for _, str := range c.sendStreams {
+		str.CancelWrite(resetCode)
+	}
+	for _, str := range c.receiveStreams {
+		str.CancelRead(resetCode)
+	}
+}
+
 // Context returns a context that is closed when the connection is closed.
 func (c *Conn) Context() context.Context {
 	return c.ctx
@@ -134,7 +307,8 @@ func (c *Conn) AcceptStream(ctx context.Context) (Stream, error) {
 	}
 	c.acceptMx.Unlock()
 	if str != nil {
-		return newStream(str, nil), nil
+		id := str.StreamID()
+		return newStream(str, nil, func() { c.untrackBidiStream(id) }), nil
 	}
 
 	select {
@@ -159,7 +333,8 @@ func (c *Conn) AcceptUniStream(ctx context.Context) (ReceiveStream, error) {
 	}
 	c.acceptUniMx.Unlock()
 	if str != nil {
-		return newReceiveStream(str), nil
+		id := str.StreamID()
+		return newReceiveStream(str, func() { c.untrackReceiveStream(id) }), nil
 	}
 
 	select {
@@ -176,44 +351,111 @@ func (c *Conn) OpenStream() (Stream, error) {
 	if c.isClosed() {
 		return nil, c.closeErr
 	}
+	if atomic.LoadUint32(&c.locallyDraining) == 1 {
+		return nil, errSessionIsDraining
+	}
 	str, err := c.qconn.OpenStream()
 	if err != nil {
 		return nil, err
 	}
-	return newStream(str, c.streamHdr), nil
+	c.trackBidiStream(str)
+	id := str.StreamID()
+	return newStream(str, c.streamHdr, func() { c.untrackBidiStream(id) }), nil
 }
 
 func (c *Conn) OpenStreamSync(ctx context.Context) (Stream, error) {
 	if c.isClosed() {
 		return nil, c.closeErr
 	}
+	if atomic.LoadUint32(&c.locallyDraining) == 1 {
+		return nil, errSessionIsDraining
+	}
 	str, err := c.qconn.OpenStreamSync(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return newStream(str, c.streamHdr), nil
+	c.trackBidiStream(str)
+	id := str.StreamID()
+	return newStream(str, c.streamHdr, func() { c.untrackBidiStream(id) }), nil
 }
 
 func (c *Conn) OpenUniStream() (SendStream, error) {
 	if c.isClosed() {
 		return nil, c.closeErr
 	}
+	if atomic.LoadUint32(&c.locallyDraining) == 1 {
+		return nil, errSessionIsDraining
+	}
 	str, err := c.qconn.OpenUniStream()
 	if err != nil {
 		return nil, err
 	}
-	return newSendStream(str, c.uniStreamHdr), nil
+	c.trackSendStream(str)
+	id := str.StreamID()
+	return newSendStream(str, c.uniStreamHdr, func() { c.untrackSendStream(id) }), nil
 }
 
 func (c *Conn) OpenUniStreamSync(ctx context.Context) (SendStream, error) {
 	if c.isClosed() {
 		return nil, c.closeErr
 	}
+	if atomic.LoadUint32(&c.locallyDraining) == 1 {
+		return nil, errSessionIsDraining
+	}
 	str, err := c.qconn.OpenUniStreamSync(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return newSendStream(str, c.uniStreamHdr), nil
+	c.trackSendStream(str)
+	id := str.StreamID()
+	return newSendStream(str, c.uniStreamHdr, func() { c.untrackSendStream(id) }), nil
+}
+
+// SendDatagram sends a datagram on the WebTransport session. It returns
+// errDatagramsNotSupported if the peer didn't negotiate support for datagrams.
+func (c *Conn) SendDatagram(b []byte) error {
+	if !c.supportsDatagrams {
+		return errDatagramsNotSupported
+	}
+	data := make([]byte, 0, len(c.datagramHdr)+len(b))
+	data = append(data, c.datagramHdr...)
+	data = append(data, b...)
+	return c.conn.SendMessage(data)
+}
+
+// ReceiveDatagram returns the next datagram received on this session, blocking
+// until one is available, the context is done, or the session is closed.
+func (c *Conn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	if !c.supportsDatagrams {
+		return nil, errDatagramsNotSupported
+	}
+	if c.isClosed() {
+		return nil, c.closeErr
+	}
+	if data, ok := c.datagramQueue.pop(); ok {
+		return data, nil
+	}
+	select {
+	case <-c.ctx.Done():
+		return nil, c.closeErr
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.datagramQueue.notify:
+		return c.ReceiveDatagram(ctx)
+	}
+}
+
+// maxQUICDatagramSize is a conservative upper bound on the size of a QUIC DATAGRAM
+// frame's payload: quic.Connection exposes no accessor for the negotiated maximum, so
+// we assume the smallest realistic path MTU (1200 bytes) minus room for the short
+// header and the DATAGRAM frame's own type/length fields.
+const maxQUICDatagramSize = 1145
+
+// MaxDatagramSize returns the maximum size of a datagram payload that can be passed
+// to SendDatagram without it being rejected by the QUIC connection, accounting for
+// this session's datagram framing overhead.
+func (c *Conn) MaxDatagramSize() int {
+	return maxQUICDatagramSize - len(c.datagramHdr)
 }
 
 func (c *Conn) LocalAddr() net.Addr {
@@ -224,6 +466,42 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.qconn.RemoteAddr()
 }
 
+// Close closes the session with error code 0 and no reason. See CloseWithError for
+// details.
 func (c *Conn) Close() error {
+	return c.CloseWithError(0, "")
+}
+
+// CloseWithError closes the session, telling the peer why via a
+// CLOSE_WEBTRANSPORT_SESSION capsule on the CONNECT request stream. All streams
+// belonging to this session are reset, and pending/future Accept* and Open* calls
+// fail with a *SessionError carrying code and reason. It is idempotent: only the
+// first call (whether to Close or CloseWithError) has any effect.
+func (c *Conn) CloseWithError(code SessionErrorCode, reason string) error {
+	c.closeOnce.Do(func() {
+		// Set closeErr before closing requestStr: closing it unblocks handleConn's
+		// read loop concurrently, which would otherwise race to set closeErr first
+		// with a generic "session closed" error instead of this *SessionError.
+		c.setClosed(&SessionError{Code: code, Reason: reason})
+		// Best-effort: tell the peer why we're closing. We ignore write errors here,
+		// since we're tearing down the session regardless.
+		writeCapsule(c.requestStr, closeWebtransportSessionCapsuleType, appendCloseWebtransportSessionCapsule(code, reason))
+		c.requestStr.Close()
+		c.resetAllStreams(code)
+		getOrCreateSessionManager(c.qconn, c.conn).unregister(c.sessionID)
+		c.ctxCancel()
+	})
 	return nil
 }
+
+// Drain starts the closing handshake: it tells the peer (via a
+// DRAIN_WEBTRANSPORT_SESSION capsule) that no new streams should be opened on this
+// session, while letting already-open streams run to completion. Streams opened
+// locally after Drain was called fail with errSessionIsDraining. Use Close or
+// CloseWithError once the session is ready to be torn down entirely.
+func (c *Conn) Drain() error {
+	if !atomic.CompareAndSwapUint32(&c.locallyDraining, 0, 1) {
+		return nil
+	}
+	return writeCapsule(c.requestStr, drainWebtransportSessionCapsuleType, nil)
+}
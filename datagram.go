@@ -0,0 +1,59 @@
+package webtransport
+
+import (
+	"errors"
+	"sync"
+)
+
+// errDatagramsNotSupported is returned by SendDatagram and ReceiveDatagram when the
+// peer didn't negotiate support for HTTP/3 datagrams (SETTINGS_H3_DATAGRAM).
+var errDatagramsNotSupported = errors.New("webtransport: peer doesn't support datagrams")
+
+// DefaultMaxDatagramQueueLen is the number of datagrams buffered per session when no
+// explicit queue length is configured on the Server or Dialer.
+const DefaultMaxDatagramQueueLen = 32
+
+// datagramQueue is a bounded FIFO queue of received, de-framed datagram payloads.
+// Once full, adding a new datagram drops the oldest one, so a session whose
+// application isn't reading fast enough can't make the sender stall.
+type datagramQueue struct {
+	mx     sync.Mutex
+	maxLen int
+	queue  [][]byte
+	notify chan struct{}
+}
+
+func newDatagramQueue(maxLen int) *datagramQueue {
+	if maxLen <= 0 {
+		maxLen = DefaultMaxDatagramQueueLen
+	}
+	return &datagramQueue{
+		maxLen: maxLen,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (q *datagramQueue) add(data []byte) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if len(q.queue) >= q.maxLen {
+		// The queue is full: drop the oldest datagram to make room for the new one.
+		q.queue = q.queue[1:]
+	}
+	q.queue = append(q.queue, data)
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (q *datagramQueue) pop() ([]byte, bool) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	if len(q.queue) == 0 {
+		return nil, false
+	}
+	data := q.queue[0]
+	q.queue = q.queue[1:]
+	return data, true
+}
@@ -0,0 +1,51 @@
+package webtransport
+
+import "testing"
+
+func TestDatagramQueuePopEmpty(t *testing.T) {
+	q := newDatagramQueue(4)
+	if _, ok := q.pop(); ok {
+		t.Fatal("expected pop on an empty queue to return ok=false")
+	}
+}
+
+func TestDatagramQueueFIFO(t *testing.T) {
+	q := newDatagramQueue(4)
+	q.add([]byte("a"))
+	q.add([]byte("b"))
+
+	data, ok := q.pop()
+	if !ok || string(data) != "a" {
+		t.Fatalf("got %q, %v; want %q, true", data, ok, "a")
+	}
+	data, ok = q.pop()
+	if !ok || string(data) != "b" {
+		t.Fatalf("got %q, %v; want %q, true", data, ok, "b")
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("expected the queue to be empty after popping both datagrams")
+	}
+}
+
+func TestDatagramQueueDropsOldestWhenFull(t *testing.T) {
+	q := newDatagramQueue(2)
+	q.add([]byte("a"))
+	q.add([]byte("b"))
+	q.add([]byte("c")) // queue is full: "a" should be dropped to make room
+
+	data, ok := q.pop()
+	if !ok || string(data) != "b" {
+		t.Fatalf("got %q, %v; want %q, true", data, ok, "b")
+	}
+	data, ok = q.pop()
+	if !ok || string(data) != "c" {
+		t.Fatalf("got %q, %v; want %q, true", data, ok, "c")
+	}
+}
+
+func TestNewDatagramQueueDefaultsMaxLen(t *testing.T) {
+	q := newDatagramQueue(0)
+	if q.maxLen != DefaultMaxDatagramQueueLen {
+		t.Fatalf("got maxLen %d, want %d", q.maxLen, DefaultMaxDatagramQueueLen)
+	}
+}
@@ -0,0 +1,45 @@
+package webtransport
+
+import (
+	"errors"
+	"math"
+)
+
+// firstErrorCode and errorCodeBucketSize implement the mapping between WebTransport
+// application error codes and the error codes used on the underlying HTTP/3
+// connection (stream resets and STOP_SENDING), as defined in the WebTransport draft.
+// Every errorCodeBucketSize+1-th HTTP/3 code in that range is reserved (GREASE) and
+// is skipped by the mapping.
+const (
+	firstErrorCode      = 0x52e4a40fa8db
+	errorCodeBucketSize = 0x1e
+)
+
+// errInvalidErrorCode is returned when translating an HTTP/3 error code that doesn't
+// fall within the range used for WebTransport application error codes.
+var errInvalidErrorCode = errors.New("invalid WebTransport error code")
+
+// webtransportCodeToHTTPCode converts a 32-bit WebTransport application error code
+// into the (much larger) HTTP/3 error code used to reset the underlying QUIC stream.
+func webtransportCodeToHTTPCode(n uint64) uint64 {
+	return firstErrorCode + n + n/errorCodeBucketSize
+}
+
+// httpCodeToWebtransportCode is the inverse of webtransportCodeToHTTPCode. It returns
+// errInvalidErrorCode if h doesn't fall within the WebTransport error code range, or
+// lands on one of the reserved (GREASE) HTTP/3 codes skipped by the mapping.
+func httpCodeToWebtransportCode(h uint64) (uint32, error) {
+	if h < firstErrorCode {
+		return 0, errInvalidErrorCode
+	}
+	offset := h - firstErrorCode
+	bucket := offset / (errorCodeBucketSize + 1)
+	if offset%(errorCodeBucketSize+1) == errorCodeBucketSize {
+		return 0, errInvalidErrorCode
+	}
+	n := offset - bucket
+	if n > math.MaxUint32 {
+		return 0, errInvalidErrorCode
+	}
+	return uint32(n), nil
+}
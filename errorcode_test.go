@@ -0,0 +1,62 @@
+package webtransport
+
+import (
+	"math"
+	"testing"
+)
+
+// TestErrorCodeMappingRoundTrip checks that httpCodeToWebtransportCode inverts
+// webtransportCodeToHTTPCode at the boundaries of every 0x1e-sized bucket across the
+// full 0..2^32-1 range of WebTransport application error codes. Under -short, only a
+// sample of buckets spread across the range is checked, to keep the test fast.
+func TestErrorCodeMappingRoundTrip(t *testing.T) {
+	check := func(n uint64) {
+		t.Helper()
+		h := webtransportCodeToHTTPCode(n)
+		got, err := httpCodeToWebtransportCode(h)
+		if err != nil {
+			t.Fatalf("round trip failed for %#x (http code %#x): %v", n, h, err)
+		}
+		if uint64(got) != n {
+			t.Fatalf("round trip mismatch for %#x: got back %#x", n, got)
+		}
+	}
+
+	check(0)
+	check(math.MaxUint32)
+
+	step := uint64(1)
+	if testing.Short() {
+		step = (math.MaxUint32 / errorCodeBucketSize) / 1000
+		if step == 0 {
+			step = 1
+		}
+	}
+	for bucket := uint64(0); bucket*errorCodeBucketSize <= math.MaxUint32; bucket += step {
+		first := bucket * errorCodeBucketSize
+		check(first)
+		if last := first + errorCodeBucketSize - 1; last <= math.MaxUint32 {
+			check(last)
+		}
+	}
+}
+
+// TestErrorCodeMappingRejectsReservedCodes verifies that the HTTP/3 codes skipped by
+// webtransportCodeToHTTPCode (the GREASE/reserved slot at the end of every bucket)
+// are rejected by the inverse mapping, rather than silently aliasing to a code.
+func TestErrorCodeMappingRejectsReservedCodes(t *testing.T) {
+	for bucket := uint64(0); bucket < 1000; bucket++ {
+		reserved := firstErrorCode + bucket*(errorCodeBucketSize+1) + errorCodeBucketSize
+		if _, err := httpCodeToWebtransportCode(reserved); err == nil {
+			t.Fatalf("expected %#x (bucket %d) to be rejected as a reserved code", reserved, bucket)
+		}
+	}
+}
+
+// TestErrorCodeMappingRejectsCodesBelowRange verifies that HTTP/3 codes below the
+// WebTransport error code range are rejected rather than producing a bogus result.
+func TestErrorCodeMappingRejectsCodesBelowRange(t *testing.T) {
+	if _, err := httpCodeToWebtransportCode(firstErrorCode - 1); err == nil {
+		t.Fatal("expected a code below firstErrorCode to be rejected")
+	}
+}
@@ -0,0 +1,35 @@
+package webtransport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errSessionIsDraining is returned by OpenStream, OpenStreamSync, OpenUniStream and
+// OpenUniStreamSync after Drain has been called locally.
+var errSessionIsDraining = errors.New("webtransport: session is draining")
+
+// errUnexpectedFrameType is returned when a stream doesn't start with the expected
+// WEBTRANSPORT_STREAM / WEBTRANSPORT_UNI_STREAM frame type.
+var errUnexpectedFrameType = errors.New("webtransport: unexpected frame type")
+
+// SessionErrorCode is an application-defined error code used when closing a
+// WebTransport session.
+type SessionErrorCode uint32
+
+// SessionError is returned from the accept and open methods, as well as from
+// ReceiveDatagram, once the WebTransport session has been closed, either locally or
+// by the peer sending a CLOSE_WEBTRANSPORT_SESSION capsule. The context returned by
+// Context() is cancelled at the same time, but its Err() is context.Canceled, not
+// the SessionError.
+type SessionError struct {
+	Code   SessionErrorCode
+	Reason string
+	// Remote is true if the session was closed by the peer, and false if it was
+	// closed locally (via Conn.Close or Conn.CloseWithError).
+	Remote bool
+}
+
+func (e *SessionError) Error() string {
+	return fmt.Sprintf("Application error %d: %s", e.Code, e.Reason)
+}
@@ -0,0 +1,161 @@
+package webtransport
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/lucas-clemente/quic-go/quicvarint"
+)
+
+const (
+	webTransportFrameType     = 0x41 // WEBTRANSPORT_STREAM, see draft-ietf-webtrans-http3
+	webTransportUniStreamType = 0x54 // WEBTRANSPORT_UNI_STREAM, see draft-ietf-webtrans-http3
+)
+
+// errorCodeSessionGone is the HTTP/3 error code used to reset a WEBTRANSPORT_STREAM
+// or WEBTRANSPORT_UNI_STREAM that carries a session ID this connection doesn't know
+// about, as defined in the WebTransport draft.
+const errorCodeSessionGone quic.StreamErrorCode = 0x170d7b68
+
+// sessionManager demultiplexes everything belonging to WebTransport sessions that
+// share a single underlying QUIC connection: streams (by reading the session ID
+// prefix off every incoming bidi/uni stream) and datagrams (by their Quarter Stream
+// ID, see draft-ietf-webtrans-http3). One sessionManager is created per QUIC
+// connection, the first time a session is registered on it.
+type sessionManager struct {
+	mx    sync.RWMutex
+	conns map[sessionID]*Conn
+}
+
+var (
+	sessionManagersMx sync.Mutex
+	sessionManagers   = make(map[http3.StreamCreator]*sessionManager)
+	// sessionManagersByConn lets the datagram dispatch loop, which only has access to
+	// the quic.Connection, find the same sessionManager used for stream dispatch.
+	sessionManagersByConn = make(map[quic.Connection]*sessionManager)
+)
+
+// getOrCreateSessionManager returns the sessionManager responsible for qconn,
+// creating it (and starting its datagram dispatch loop) on first use.
+func getOrCreateSessionManager(qconn http3.StreamCreator, conn quic.Connection) *sessionManager {
+	sessionManagersMx.Lock()
+	defer sessionManagersMx.Unlock()
+	if m, ok := sessionManagers[qconn]; ok {
+		return m
+	}
+	m := &sessionManager{conns: make(map[sessionID]*Conn)}
+	sessionManagers[qconn] = m
+	sessionManagersByConn[conn] = m
+	go m.dispatchDatagrams(qconn, conn)
+	return m
+}
+
+func (m *sessionManager) register(id sessionID, c *Conn) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.conns[id] = c
+}
+
+func (m *sessionManager) unregister(id sessionID) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.conns, id)
+}
+
+func (m *sessionManager) get(id sessionID) (*Conn, bool) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	c, ok := m.conns[id]
+	return c, ok
+}
+
+// HandleStream reads the WEBTRANSPORT_STREAM frame type and session ID off str and
+// routes it to the matching Conn, resetting str if the frame is malformed or no
+// session with that ID exists (anymore) on this connection.
+func (m *sessionManager) HandleStream(str quic.Stream) {
+	id, err := m.parseSessionID(str, webTransportFrameType)
+	if err != nil {
+		str.CancelRead(errorCodeSessionGone)
+		str.CancelWrite(errorCodeSessionGone)
+		return
+	}
+	c, ok := m.get(id)
+	if !ok {
+		str.CancelRead(errorCodeSessionGone)
+		str.CancelWrite(errorCodeSessionGone)
+		return
+	}
+	c.addStream(str)
+}
+
+// HandleUniStream reads the WEBTRANSPORT_UNI_STREAM type and session ID off str and
+// routes it to the matching Conn, resetting str if the frame is malformed or no
+// session with that ID exists (anymore) on this connection.
+func (m *sessionManager) HandleUniStream(str quic.ReceiveStream) {
+	id, err := m.parseSessionID(str, webTransportUniStreamType)
+	if err != nil {
+		str.CancelRead(errorCodeSessionGone)
+		return
+	}
+	c, ok := m.get(id)
+	if !ok {
+		str.CancelRead(errorCodeSessionGone)
+		return
+	}
+	c.addUniStream(str)
+}
+
+func (m *sessionManager) parseSessionID(r io.Reader, wantFrameType uint64) (sessionID, error) {
+	qr := singleByteReader{r}
+	typ, err := quicvarint.Read(qr)
+	if err != nil {
+		return 0, err
+	}
+	if typ != wantFrameType {
+		return 0, errUnexpectedFrameType
+	}
+	id, err := quicvarint.Read(qr)
+	if err != nil {
+		return 0, err
+	}
+	return sessionID(id), nil
+}
+
+// dispatchDatagrams demultiplexes the datagrams received on conn to the WebTransport
+// session they belong to, by their Quarter Stream ID prefix, until conn is closed.
+func (m *sessionManager) dispatchDatagrams(qconn http3.StreamCreator, conn quic.Connection) {
+	// conn.ReceiveMessage returns an error both when the connection is closed and
+	// when datagram support is disabled for it (in which case it errors immediately
+	// and keeps doing so on every call). Either way there's nothing more to
+	// dispatch, but the sessionManager itself must only be torn down once the QUIC
+	// connection has actually gone away, or sessions still being routed by stream
+	// would get dropped.
+	defer func() {
+		<-conn.Context().Done()
+		sessionManagersMx.Lock()
+		delete(sessionManagers, qconn)
+		delete(sessionManagersByConn, conn)
+		sessionManagersMx.Unlock()
+	}()
+	for {
+		data, err := conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		br := bytes.NewReader(data)
+		qsID, err := quicvarint.Read(br)
+		if err != nil {
+			// Malformed datagram framing: drop it.
+			continue
+		}
+		c, ok := m.get(sessionID(qsID * 4))
+		if !ok {
+			// No session with this ID on this connection (anymore): drop it.
+			continue
+		}
+		c.datagramQueue.add(data[len(data)-br.Len():])
+	}
+}
@@ -0,0 +1,99 @@
+package webtransport
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/quicvarint"
+
+	"testing"
+)
+
+// fakeStream is a minimal quic.Stream fake, recording whether and how it was reset.
+type fakeStream struct {
+	*bytes.Buffer
+	readCode, writeCode         quic.StreamErrorCode
+	readCanceled, writeCanceled bool
+}
+
+func newFakeStream(data []byte) *fakeStream {
+	return &fakeStream{Buffer: bytes.NewBuffer(data)}
+}
+
+func (s *fakeStream) StreamID() quic.StreamID            { return 0 }
+func (s *fakeStream) Close() error                       { return nil }
+func (s *fakeStream) Context() context.Context           { return context.Background() }
+func (s *fakeStream) SetReadDeadline(t time.Time) error  { return nil }
+func (s *fakeStream) SetWriteDeadline(t time.Time) error { return nil }
+func (s *fakeStream) SetDeadline(t time.Time) error      { return nil }
+func (s *fakeStream) CancelRead(code quic.StreamErrorCode) {
+	s.readCanceled = true
+	s.readCode = code
+}
+func (s *fakeStream) CancelWrite(code quic.StreamErrorCode) {
+	s.writeCanceled = true
+	s.writeCode = code
+}
+
+func appendVarintFrame(frameType, id uint64) []byte {
+	buf := &bytes.Buffer{}
+	quicvarint.Write(buf, frameType)
+	quicvarint.Write(buf, id)
+	return buf.Bytes()
+}
+
+func TestSessionManagerHandleStreamUnknownSession(t *testing.T) {
+	m := &sessionManager{conns: make(map[sessionID]*Conn)}
+	str := newFakeStream(appendVarintFrame(webTransportFrameType, 1234))
+
+	m.HandleStream(str)
+
+	if !str.readCanceled || str.readCode != errorCodeSessionGone {
+		t.Fatalf("expected read side reset with %#x, got canceled=%v code=%#x", errorCodeSessionGone, str.readCanceled, str.readCode)
+	}
+	if !str.writeCanceled || str.writeCode != errorCodeSessionGone {
+		t.Fatalf("expected write side reset with %#x, got canceled=%v code=%#x", errorCodeSessionGone, str.writeCanceled, str.writeCode)
+	}
+}
+
+func TestSessionManagerHandleStreamMalformedFrame(t *testing.T) {
+	m := &sessionManager{conns: make(map[sessionID]*Conn)}
+	// wrong frame type
+	str := newFakeStream(appendVarintFrame(0x1234, 1234))
+
+	m.HandleStream(str)
+
+	if !str.readCanceled || str.readCode != errorCodeSessionGone {
+		t.Fatalf("expected read side reset with %#x, got canceled=%v code=%#x", errorCodeSessionGone, str.readCanceled, str.readCode)
+	}
+	if !str.writeCanceled || str.writeCode != errorCodeSessionGone {
+		t.Fatalf("expected write side reset with %#x, got canceled=%v code=%#x", errorCodeSessionGone, str.writeCanceled, str.writeCode)
+	}
+}
+
+// fakeReceiveStream is a minimal quic.ReceiveStream fake, recording how it was reset.
+type fakeReceiveStream struct {
+	*bytes.Buffer
+	code     quic.StreamErrorCode
+	canceled bool
+}
+
+func (s *fakeReceiveStream) StreamID() quic.StreamID           { return 0 }
+func (s *fakeReceiveStream) SetReadDeadline(t time.Time) error { return nil }
+func (s *fakeReceiveStream) CancelRead(code quic.StreamErrorCode) {
+	s.canceled = true
+	s.code = code
+}
+
+func TestSessionManagerHandleUniStreamUnknownSession(t *testing.T) {
+	m := &sessionManager{conns: make(map[sessionID]*Conn)}
+	str := &fakeReceiveStream{Buffer: bytes.NewBuffer(appendVarintFrame(webTransportUniStreamType, 5678))}
+
+	m.HandleUniStream(str)
+
+	if !str.canceled || str.code != errorCodeSessionGone {
+		t.Fatalf("expected stream reset with %#x, got canceled=%v code=%#x", errorCodeSessionGone, str.canceled, str.code)
+	}
+}
@@ -0,0 +1,196 @@
+package webtransport
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+)
+
+// StreamErrorCode is an application-defined error code used when resetting or
+// stopping a Stream, SendStream or ReceiveStream.
+type StreamErrorCode uint32
+
+// StreamError is returned by Read and Write calls on a stream that was reset or
+// stopped, either by the peer or locally via CancelRead / CancelWrite.
+type StreamError struct {
+	ErrorCode StreamErrorCode
+	Remote    bool
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("stream reset with error code %d", e.ErrorCode)
+}
+
+// SendStream is a unidirectional Send Stream.
+type SendStream interface {
+	// Write writes data to the stream.
+	Write([]byte) (int, error)
+	// Close closes the send direction of the stream.
+	Close() error
+	// CancelWrite aborts sending on this stream, telling the peer the given
+	// WebTransport application error code.
+	CancelWrite(StreamErrorCode)
+	SetWriteDeadline(t time.Time) error
+}
+
+// ReceiveStream is a unidirectional Receive Stream.
+type ReceiveStream interface {
+	// Read reads data from the stream.
+	Read([]byte) (int, error)
+	// CancelRead aborts receiving on this stream, telling the peer the given
+	// WebTransport application error code.
+	CancelRead(StreamErrorCode)
+	SetReadDeadline(t time.Time) error
+}
+
+// Stream is a bidirectional stream.
+type Stream interface {
+	SendStream
+	ReceiveStream
+}
+
+// translateStreamError converts a reset or STOP_SENDING error received from quic-go
+// into the *StreamError carrying the original WebTransport application error code,
+// if err is such an error.
+func translateStreamError(err error) error {
+	var quicErr *quic.StreamError
+	if !errors.As(err, &quicErr) {
+		return err
+	}
+	code, cerr := httpCodeToWebtransportCode(uint64(quicErr.ErrorCode))
+	if cerr != nil {
+		return err
+	}
+	return &StreamError{ErrorCode: StreamErrorCode(code), Remote: true}
+}
+
+type sendStream struct {
+	str quic.SendStream
+
+	hdr []byte // only set for locally-opened streams, written before the first Write
+
+	// onDone, if set, is called the first time the send side of this stream
+	// completes (Close or CancelWrite), so the owning Conn can stop tracking it.
+	onDone   func()
+	doneOnce sync.Once
+}
+
+func newSendStream(str quic.SendStream, hdr []byte, onDone func()) SendStream {
+	return &sendStream{str: str, hdr: hdr, onDone: onDone}
+}
+
+func (s *sendStream) markDone() {
+	if s.onDone != nil {
+		s.doneOnce.Do(s.onDone)
+	}
+}
+
+func (s *sendStream) Write(b []byte) (int, error) {
+	if s.hdr != nil {
+		if _, err := s.str.Write(s.hdr); err != nil {
+			s.markDone()
+			return 0, translateStreamError(err)
+		}
+		s.hdr = nil
+	}
+	n, err := s.str.Write(b)
+	if err != nil {
+		s.markDone()
+		return n, translateStreamError(err)
+	}
+	return n, nil
+}
+
+func (s *sendStream) Close() error {
+	err := s.str.Close()
+	s.markDone()
+	return err
+}
+
+func (s *sendStream) CancelWrite(code StreamErrorCode) {
+	s.str.CancelWrite(quic.StreamErrorCode(webtransportCodeToHTTPCode(uint64(code))))
+	s.markDone()
+}
+
+func (s *sendStream) SetWriteDeadline(t time.Time) error {
+	return s.str.SetWriteDeadline(t)
+}
+
+type receiveStream struct {
+	str quic.ReceiveStream
+
+	// onDone, if set, is called the first time the receive side of this stream
+	// completes (a Read error, or CancelRead), so the owning Conn can stop tracking
+	// it.
+	onDone   func()
+	doneOnce sync.Once
+}
+
+func newReceiveStream(str quic.ReceiveStream, onDone func()) ReceiveStream {
+	return &receiveStream{str: str, onDone: onDone}
+}
+
+func (s *receiveStream) markDone() {
+	if s.onDone != nil {
+		s.doneOnce.Do(s.onDone)
+	}
+}
+
+func (s *receiveStream) Read(b []byte) (int, error) {
+	n, err := s.str.Read(b)
+	if err != nil {
+		s.markDone()
+		return n, translateStreamError(err)
+	}
+	return n, nil
+}
+
+func (s *receiveStream) CancelRead(code StreamErrorCode) {
+	s.str.CancelRead(quic.StreamErrorCode(webtransportCodeToHTTPCode(uint64(code))))
+	s.markDone()
+}
+
+func (s *receiveStream) SetReadDeadline(t time.Time) error {
+	return s.str.SetReadDeadline(t)
+}
+
+// stream is a bidirectional WebTransport stream, combining a sendStream and a
+// receiveStream backed by the same underlying quic.Stream.
+type stream struct {
+	*sendStream
+	*receiveStream
+}
+
+// newStream wraps str, prepending hdr (if non-nil) before the first Write. onDone,
+// if set, is called once both the send and the receive side of the stream have
+// completed, so the owning Conn can stop tracking it.
+func newStream(str quic.Stream, hdr []byte, onDone func()) Stream {
+	var (
+		mx          sync.Mutex
+		sendDone    bool
+		receiveDone bool
+	)
+	markHalfDone := func(isSend bool) {
+		mx.Lock()
+		if isSend {
+			sendDone = true
+		} else {
+			receiveDone = true
+		}
+		bothDone := sendDone && receiveDone
+		mx.Unlock()
+		if bothDone && onDone != nil {
+			onDone()
+		}
+	}
+	s := &stream{
+		sendStream:    &sendStream{str: str, hdr: hdr},
+		receiveStream: &receiveStream{str: str},
+	}
+	s.sendStream.onDone = func() { markHalfDone(true) }
+	s.receiveStream.onDone = func() { markHalfDone(false) }
+	return s
+}